@@ -0,0 +1,94 @@
+// Package session provides functions for initializing and accessing
+// session-local data as a request goes through the system.
+package session
+
+import (
+	"context"
+	"math/rand"
+
+	"v2ray.com/core/common/errors"
+	"v2ray.com/core/common/net"
+)
+
+// ID of a session.
+type ID uint32
+
+// NewID generates a new ID. The generated ID is highly likely to be unique,
+// but is not cryptographically secure.
+func NewID() ID {
+	for {
+		id := ID(rand.Uint32())
+		if id != 0 {
+			return id
+		}
+	}
+}
+
+// ExportIDToError transfers the session ID from ctx to the error, if any.
+func ExportIDToError(ctx context.Context) errors.ExportOption {
+	id := IDFromContext(ctx)
+	return func(h *errors.Holder) {
+		h.SessionID = uint32(id)
+	}
+}
+
+// ProxyProtocolInfo carries the metadata parsed from a PROXY protocol v1/v2
+// header prepended to an inbound connection, preserving the true L4 client
+// even when V2Ray itself terminates TLS.
+type ProxyProtocolInfo struct {
+	// Source is the original source address reported by the proxy.
+	Source net.Destination
+	// Destination is the original destination address reported by the proxy.
+	Destination net.Destination
+	// TLV holds vendor-specific Type-Length-Value records attached to the
+	// header, such as the AWS VPC endpoint ID or GCP PSC connection ID.
+	TLV map[byte][]byte
+}
+
+// Inbound is the metadata of an inbound connection.
+type Inbound struct {
+	// Source address of the inbound connection.
+	Source net.Destination
+	// Gateway address
+	Gateway net.Destination
+	// Tag of the inbound proxy that handles the connection.
+	Tag string
+	// ProxyProtocol carries the PROXY protocol metadata for this connection,
+	// when the listener has AcceptProxyProtocol enabled and a header was
+	// present. Nil otherwise.
+	ProxyProtocol *ProxyProtocolInfo
+}
+
+type sessionKey int
+
+const (
+	idSessionKey sessionKey = iota
+	inboundSessionKey
+)
+
+// ContextWithID returns a new context with the given ID.
+func ContextWithID(ctx context.Context, id ID) context.Context {
+	return context.WithValue(ctx, idSessionKey, id)
+}
+
+// IDFromContext returns the ID in the given context, or 0 if not present.
+func IDFromContext(ctx context.Context) ID {
+	if id, ok := ctx.Value(idSessionKey).(ID); ok {
+		return id
+	}
+	return 0
+}
+
+// ContextWithInbound returns a new context with the given Inbound.
+func ContextWithInbound(ctx context.Context, inbound *Inbound) context.Context {
+	return context.WithValue(ctx, inboundSessionKey, inbound)
+}
+
+// InboundFromContext returns the Inbound in the given context, or nil if not
+// present.
+func InboundFromContext(ctx context.Context) *Inbound {
+	if inbound, ok := ctx.Value(inboundSessionKey).(*Inbound); ok {
+		return inbound
+	}
+	return nil
+}
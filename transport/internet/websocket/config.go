@@ -0,0 +1,66 @@
+package websocket
+
+import (
+	"net/http"
+	"strings"
+
+	"v2ray.com/core/common"
+	"v2ray.com/core/transport/internet"
+)
+
+const protocolName = "websocket"
+
+// Header is a single HTTP header to send on the WebSocket upgrade response.
+type Header struct {
+	Key   string
+	Value string
+}
+
+// Config is the protocol settings for the WebSocket transport.
+type Config struct {
+	// Path is the HTTP path that the WebSocket upgrade request must match.
+	Path string
+	// AcceptProxyProtocol indicates whether the listener should accept a
+	// PROXY protocol header prepended to incoming TCP connections.
+	AcceptProxyProtocol bool
+	// EarlyDataEnabled allows the server to accept client early data piggybacked
+	// on the WebSocket upgrade request, saving a round trip on connection setup.
+	EarlyDataEnabled bool
+	// EarlyDataHeaderName is the HTTP header carrying base64url-encoded early
+	// data. When empty, early data is instead read from a base64url suffix
+	// appended to Path.
+	EarlyDataHeaderName string
+	// Header lists additional response headers sent on a successful upgrade.
+	Header []*Header
+	// FallbackAddr is the upstream HTTP server that non-matching requests are
+	// reverse-proxied to, e.g. "http://127.0.0.1:8080". When empty, a canned
+	// 404 is returned instead.
+	FallbackAddr string
+	// FallbackPath rewrites the request path before it is forwarded to
+	// FallbackAddr. When empty, the original request path is kept.
+	FallbackPath string
+}
+
+// GetNormalizedPath returns Path ensured to start with a leading slash.
+func (c *Config) GetNormalizedPath() string {
+	path := c.Path
+	if !strings.HasPrefix(path, "/") {
+		return "/" + path
+	}
+	return path
+}
+
+// GetResponseHeader builds the extra headers sent on a successful upgrade.
+func (c *Config) GetResponseHeader() http.Header {
+	header := http.Header{}
+	for _, h := range c.Header {
+		header.Add(h.Key, h.Value)
+	}
+	return header
+}
+
+func init() {
+	common.Must(internet.RegisterProtocolConfigCreator(protocolName, func() interface{} {
+		return new(Config)
+	}))
+}
@@ -0,0 +1,258 @@
+package websocket
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pires/go-proxyproto"
+
+	"v2ray.com/core/common/errors"
+	v2net "v2ray.com/core/common/net"
+	"v2ray.com/core/common/session"
+)
+
+var _ net.Conn = (*connection)(nil)
+
+// connection is a wrapper for net.Conn over WebSocket connection.
+type connection struct {
+	conn        *websocket.Conn
+	reader      io.Reader
+	earlyData   io.Reader
+	remoteAddr  net.Addr
+	proxyHeader *proxyproto.Header
+	ctx         context.Context
+}
+
+// newConnection wraps conn into a net.Conn. If earlyData is non-nil, its
+// content is drained by Read before falling through to the underlying
+// WebSocket connection. proxyHeader, if non-nil, is the PROXY protocol
+// header parsed off the accepted TCP connection; ctx is the per-request
+// context, carrying the session.Inbound built by buildContext when a header
+// was present, so
+// that whatever builds the inbound worker for this connection can recover
+// the PROXY protocol metadata via Context() instead of re-deriving it.
+func newConnection(ctx context.Context, conn *websocket.Conn, remoteAddr net.Addr, earlyData io.Reader, proxyHeader *proxyproto.Header) *connection {
+	return &connection{
+		conn:        conn,
+		remoteAddr:  remoteAddr,
+		earlyData:   earlyData,
+		proxyHeader: proxyHeader,
+		ctx:         ctx,
+	}
+}
+
+// buildContext attaches proxyHeader's parsed PROXY protocol metadata to the
+// session.Inbound carried by ctx, creating one if the request context didn't
+// already have one. ctx comes from request.Context(), which net/http derives
+// independently per connection, so (unlike a shared http.Server.BaseContext)
+// storing per-connection state on it here cannot leak across connections.
+func buildContext(ctx context.Context, proxyHeader *proxyproto.Header) context.Context {
+	info := proxyProtocolInfo(proxyHeader)
+	if info == nil {
+		return ctx
+	}
+	inbound := session.InboundFromContext(ctx)
+	if inbound == nil {
+		inbound = &session.Inbound{}
+	}
+	inbound.ProxyProtocol = info
+	return session.ContextWithInbound(ctx, inbound)
+}
+
+// Context returns the per-connection context, carrying the session.Inbound
+// that buildContext populated with this connection's ProxyProtocolInfo, if
+// any.
+func (c *connection) Context() context.Context {
+	return c.ctx
+}
+
+// ProxyProtocolInfo returns the PROXY protocol metadata parsed for this
+// connection, or nil if the listener didn't have AcceptProxyProtocol
+// enabled or no header was present.
+func (c *connection) ProxyProtocolInfo() *session.ProxyProtocolInfo {
+	return proxyProtocolInfo(c.proxyHeader)
+}
+
+// proxyProtocolInfo converts a parsed PROXY protocol header into a
+// session.ProxyProtocolInfo, or returns nil if header is nil.
+func proxyProtocolInfo(header *proxyproto.Header) *session.ProxyProtocolInfo {
+	if header == nil {
+		return nil
+	}
+	info := &session.ProxyProtocolInfo{}
+	if header.SourceAddr != nil {
+		info.Source = v2net.DestinationFromAddr(header.SourceAddr)
+	}
+	if header.DestinationAddr != nil {
+		info.Destination = v2net.DestinationFromAddr(header.DestinationAddr)
+	}
+	if tlvs, err := header.TLVs(); err == nil && len(tlvs) > 0 {
+		info.TLV = make(map[byte][]byte, len(tlvs))
+		for _, tlv := range tlvs {
+			info.TLV[tlv.Type] = tlv.Value
+		}
+	}
+	return info
+}
+
+func (c *connection) Read(b []byte) (int, error) {
+	if c.earlyData != nil {
+		n, err := c.earlyData.Read(b)
+		if err != nil {
+			c.earlyData = nil
+			if n > 0 {
+				return n, nil
+			}
+		} else if n > 0 {
+			return n, nil
+		}
+	}
+
+	for {
+		reader, err := c.getReader()
+		if err != nil {
+			return 0, err
+		}
+
+		nBytes, err := reader.Read(b)
+		if err == io.EOF {
+			c.reader = nil
+			continue
+		}
+		return nBytes, err
+	}
+}
+
+func (c *connection) getReader() (io.Reader, error) {
+	if c.reader != nil {
+		return c.reader, nil
+	}
+
+	_, reader, err := c.conn.NextReader()
+	if err != nil {
+		return nil, err
+	}
+	c.reader = reader
+	return reader, nil
+}
+
+func (c *connection) Write(b []byte) (int, error) {
+	if err := c.conn.WriteMessage(websocket.BinaryMessage, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *connection) Close() error {
+	var errs []error
+	if err := c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second*5)); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.conn.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return errors.Combine(errs...)
+	}
+	return nil
+}
+
+func (c *connection) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *connection) RemoteAddr() net.Addr {
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.conn.RemoteAddr()
+}
+
+func (c *connection) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+func (c *connection) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *connection) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+var _ net.Conn = (*http2Connection)(nil)
+
+// http2Connection wraps the bidirectional stream of an RFC 8441 extended
+// CONNECT request into a net.Conn, since HTTP/2 exposes no underlying
+// net.Conn of its own. Once the h2/h2c Handler goroutine that owns the
+// stream returns, x/net/http2 tears the stream down, so the handler must
+// block on done until Close is called.
+type http2Connection struct {
+	reader     io.ReadCloser
+	writer     io.Writer
+	flusher    http.Flusher
+	remoteAddr net.Addr
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+func newHTTP2Connection(reader io.ReadCloser, writer io.Writer, flusher http.Flusher, remoteAddr net.Addr) *http2Connection {
+	return &http2Connection{
+		reader:     reader,
+		writer:     writer,
+		flusher:    flusher,
+		remoteAddr: remoteAddr,
+		done:       make(chan struct{}),
+	}
+}
+
+// Wait blocks until the connection has been closed.
+func (c *http2Connection) Wait() {
+	<-c.done
+}
+
+func (c *http2Connection) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+func (c *http2Connection) Write(b []byte) (int, error) {
+	n, err := c.writer.Write(b)
+	if err != nil {
+		return n, err
+	}
+	c.flusher.Flush()
+	return n, nil
+}
+
+func (c *http2Connection) Close() error {
+	c.closeOnce.Do(func() { close(c.done) })
+	return c.reader.Close()
+}
+
+func (c *http2Connection) LocalAddr() net.Addr {
+	return nil
+}
+
+func (c *http2Connection) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+func (c *http2Connection) SetDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *http2Connection) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *http2Connection) SetWriteDeadline(t time.Time) error {
+	return nil
+}
@@ -0,0 +1,83 @@
+package websocket
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/pires/go-proxyproto"
+
+	"v2ray.com/core/common/session"
+)
+
+func TestConnectionProxyProtocolInfo(t *testing.T) {
+	c := &connection{}
+	if info := c.ProxyProtocolInfo(); info != nil {
+		t.Fatalf("expected nil info without a header, got %+v", info)
+	}
+
+	source := &net.TCPAddr{IP: net.ParseIP("203.0.113.1"), Port: 51234}
+	dest := &net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 443}
+	c.proxyHeader = &proxyproto.Header{SourceAddr: source, DestinationAddr: dest}
+
+	info := c.ProxyProtocolInfo()
+	if info == nil {
+		t.Fatal("expected non-nil info once a header is set")
+	}
+	if info.Source.Address.IP().String() != source.IP.String() {
+		t.Fatalf("got source %v, want %v", info.Source.Address.IP(), source.IP)
+	}
+	if info.Destination.Address.IP().String() != dest.IP.String() {
+		t.Fatalf("got destination %v, want %v", info.Destination.Address.IP(), dest.IP)
+	}
+}
+
+func TestBuildContext(t *testing.T) {
+	t.Run("leaves ctx untouched without a header", func(t *testing.T) {
+		ctx := buildContext(context.Background(), nil)
+		if session.InboundFromContext(ctx) != nil {
+			t.Fatal("expected no Inbound to be attached without a PROXY protocol header")
+		}
+	})
+
+	t.Run("attaches ProxyProtocolInfo to a fresh Inbound", func(t *testing.T) {
+		source := &net.TCPAddr{IP: net.ParseIP("203.0.113.1"), Port: 51234}
+		header := &proxyproto.Header{SourceAddr: source}
+
+		ctx := buildContext(context.Background(), header)
+		inbound := session.InboundFromContext(ctx)
+		if inbound == nil || inbound.ProxyProtocol == nil {
+			t.Fatal("expected an Inbound carrying ProxyProtocolInfo")
+		}
+		if got := inbound.ProxyProtocol.Source.Address.IP().String(); got != source.IP.String() {
+			t.Fatalf("got source %v, want %v", got, source.IP)
+		}
+	})
+
+	t.Run("preserves an Inbound already on the context", func(t *testing.T) {
+		existing := &session.Inbound{Tag: "test-inbound"}
+		ctx := session.ContextWithInbound(context.Background(), existing)
+
+		header := &proxyproto.Header{SourceAddr: &net.TCPAddr{IP: net.ParseIP("203.0.113.1")}}
+		ctx = buildContext(ctx, header)
+
+		inbound := session.InboundFromContext(ctx)
+		if inbound != existing {
+			t.Fatal("expected buildContext to reuse the existing Inbound")
+		}
+		if inbound.Tag != "test-inbound" {
+			t.Fatalf("got Tag=%q, want %q", inbound.Tag, "test-inbound")
+		}
+		if inbound.ProxyProtocol == nil {
+			t.Fatal("expected ProxyProtocol to be set on the existing Inbound")
+		}
+	})
+}
+
+func TestConnectionContext(t *testing.T) {
+	ctx := context.WithValue(context.Background(), struct{}{}, "marker")
+	c := &connection{ctx: ctx}
+	if c.Context() != ctx {
+		t.Fatal("expected Context() to return the context passed to newConnection")
+	}
+}
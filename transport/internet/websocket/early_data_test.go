@@ -0,0 +1,83 @@
+//go:build !confonly
+// +build !confonly
+
+package websocket
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExtractEarlyData(t *testing.T) {
+	t.Run("early data disabled, path must match exactly", func(t *testing.T) {
+		h := newTestHandler(&Config{Path: "/ws"})
+
+		w := httptest.NewRecorder()
+		data, ok := h.extractEarlyData(w, httptest.NewRequest(http.MethodGet, "/ws", nil))
+		if !ok || data != nil {
+			t.Fatalf("got ok=%v data=%v, want ok=true data=nil", ok, data)
+		}
+
+		w = httptest.NewRecorder()
+		if _, ok := h.extractEarlyData(w, httptest.NewRequest(http.MethodGet, "/other", nil)); ok {
+			t.Fatal("expected mismatched path to be rejected")
+		}
+		if w.Code != http.StatusNotFound {
+			t.Fatalf("expected 404 fallback for mismatched path, got %d", w.Code)
+		}
+	})
+
+	t.Run("early data from header", func(t *testing.T) {
+		h := newTestHandler(&Config{Path: "/ws", EarlyDataEnabled: true, EarlyDataHeaderName: "Sec-WebSocket-Protocol"})
+
+		req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+		req.Header.Set("Sec-WebSocket-Protocol", base64.RawURLEncoding.EncodeToString([]byte("hello")))
+		data, ok := h.extractEarlyData(httptest.NewRecorder(), req)
+		if !ok || data == nil {
+			t.Fatalf("got ok=%v data=%v, want early data", ok, data)
+		}
+		got, err := io.ReadAll(data)
+		if err != nil || string(got) != "hello" {
+			t.Fatalf("got %q, err=%v, want %q", got, err, "hello")
+		}
+
+		w := httptest.NewRecorder()
+		if _, ok := h.extractEarlyData(w, httptest.NewRequest(http.MethodGet, "/other", nil)); ok {
+			t.Fatal("expected mismatched path to be rejected even with early data enabled")
+		}
+	})
+
+	t.Run("early data from path suffix", func(t *testing.T) {
+		h := newTestHandler(&Config{Path: "/ws", EarlyDataEnabled: true})
+
+		suffix := base64.RawURLEncoding.EncodeToString([]byte("hello"))
+		req := httptest.NewRequest(http.MethodGet, "/ws"+suffix, nil)
+		data, ok := h.extractEarlyData(httptest.NewRecorder(), req)
+		if !ok || data == nil {
+			t.Fatalf("got ok=%v data=%v, want early data", ok, data)
+		}
+		got, err := io.ReadAll(data)
+		if err != nil || string(got) != "hello" {
+			t.Fatalf("got %q, err=%v, want %q", got, err, "hello")
+		}
+
+		w := httptest.NewRecorder()
+		if _, ok := h.extractEarlyData(w, httptest.NewRequest(http.MethodGet, "/other", nil)); ok {
+			t.Fatal("expected a request outside the configured path to be rejected")
+		}
+	})
+}
+
+func TestConnectionReadDrainsEarlyDataFirst(t *testing.T) {
+	c := &connection{earlyData: bytes.NewReader([]byte("early"))}
+
+	buf := make([]byte, len("early"))
+	n, err := c.Read(buf)
+	if err != nil || string(buf[:n]) != "early" {
+		t.Fatalf("got %q, err=%v, want %q", buf[:n], err, "early")
+	}
+}
@@ -0,0 +1,89 @@
+//go:build !confonly
+// +build !confonly
+
+package websocket
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"testing"
+)
+
+func TestHandleFallback(t *testing.T) {
+	t.Run("returns the canned 404 without a configured fallback", func(t *testing.T) {
+		h := newTestHandler(&Config{Path: "/ws"})
+
+		w := httptest.NewRecorder()
+		h.handleFallback(w, httptest.NewRequest(http.MethodGet, "/other", nil))
+		if w.Code != http.StatusNotFound {
+			t.Fatalf("got %d, want %d", w.Code, http.StatusNotFound)
+		}
+	})
+
+	t.Run("reverse-proxies to the configured fallback site", func(t *testing.T) {
+		var gotPath string
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotPath = r.URL.Path
+			w.WriteHeader(http.StatusTeapot)
+		}))
+		defer upstream.Close()
+
+		target, err := url.Parse(upstream.URL)
+		if err != nil {
+			t.Fatalf("failed to parse fixture URL: %v", err)
+		}
+
+		h := newTestHandler(&Config{Path: "/ws"})
+		h.fallback = httputil.NewSingleHostReverseProxy(target)
+
+		w := httptest.NewRecorder()
+		h.handleFallback(w, httptest.NewRequest(http.MethodGet, "/probe", nil))
+		if w.Code != http.StatusTeapot {
+			t.Fatalf("got %d, want %d", w.Code, http.StatusTeapot)
+		}
+		if gotPath != "/probe" {
+			t.Fatalf("got upstream path %q, want %q", gotPath, "/probe")
+		}
+	})
+
+	t.Run("rewrites the request path when FallbackPath is set", func(t *testing.T) {
+		var gotPath string
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotPath = r.URL.Path
+		}))
+		defer upstream.Close()
+
+		target, err := url.Parse(upstream.URL)
+		if err != nil {
+			t.Fatalf("failed to parse fixture URL: %v", err)
+		}
+
+		h := newTestHandler(&Config{Path: "/ws", FallbackPath: "/rewritten"})
+		h.fallback = httputil.NewSingleHostReverseProxy(target)
+
+		w := httptest.NewRecorder()
+		h.handleFallback(w, httptest.NewRequest(http.MethodGet, "/probe", nil))
+		if gotPath != "/rewritten" {
+			t.Fatalf("got upstream path %q, want %q", gotPath, "/rewritten")
+		}
+	})
+}
+
+func TestServeExtendedConnectAppliesResponseHeaders(t *testing.T) {
+	h := newTestHandler(&Config{Path: "/ws", Header: []*Header{{Key: "X-Test", Value: "1"}}})
+	h.ln.addConn = func(c net.Conn) { go c.Close() }
+
+	req := httptest.NewRequest(http.MethodConnect, "/ws", nil)
+	req.Header.Set(":protocol", "websocket")
+
+	w := httptest.NewRecorder()
+	if !h.serveExtendedConnect(w, req) {
+		t.Fatal("expected the extended CONNECT to be handled")
+	}
+	if got := w.Header().Get("X-Test"); got != "1" {
+		t.Fatalf("got X-Test=%q, want %q", got, "1")
+	}
+}
@@ -4,14 +4,23 @@
 package websocket
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
+	"encoding/base64"
+	"io"
 	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/pires/go-proxyproto"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 
 	"v2ray.com/core/common"
 	"v2ray.com/core/common/net"
@@ -24,6 +33,24 @@ import (
 type requestHandler struct {
 	path string
 	ln   *Listener
+	// fallback reverse-proxies requests that don't match path to a real
+	// upstream site, so probing clients see a fully functional origin
+	// instead of a canned error page.
+	fallback *httputil.ReverseProxy
+}
+
+// handleFallback serves a non-matching request, either by reverse-proxying
+// it to the configured fallback site or, if none is configured, by
+// returning the canned 404.
+func (h *requestHandler) handleFallback(writer http.ResponseWriter, request *http.Request) {
+	if h.fallback == nil {
+		notFoundHandler(writer)
+		return
+	}
+	if path := h.ln.config.FallbackPath; path != "" {
+		request.URL.Path = path
+	}
+	h.fallback.ServeHTTP(writer, request)
 }
 
 var forbiddenContent = []byte(`<html>
@@ -83,42 +110,212 @@ var upgrader = &websocket.Upgrader{
 }
 
 func (h *requestHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
-	if request.URL.Path != h.path {
-		notFoundHandler(writer)
+	if h.serveExtendedConnect(writer, request) {
 		return
 	}
-	conn, err := upgrader.Upgrade(writer, request, nil)
+
+	earlyData, ok := h.extractEarlyData(writer, request)
+	if !ok {
+		return
+	}
+
+	conn, err := upgrader.Upgrade(writer, request, h.ln.config.GetResponseHeader())
 	if err != nil {
 		newError("failed to convert to WebSocket connection").Base(err).WriteToLog()
 		return
 	}
 
-	forwardedAddrs := http_proto.ParseXForwardedFor(request.Header)
+	var proxyHeader *proxyproto.Header
+	if ppConn := findProxyProtoConn(conn.UnderlyingConn()); ppConn != nil {
+		proxyHeader = ppConn.ProxyHeader()
+	}
+
 	remoteAddr := conn.RemoteAddr()
-	if len(forwardedAddrs) > 0 && forwardedAddrs[0].Family().IsIP() {
-		remoteAddr.(*net.TCPAddr).IP = forwardedAddrs[0].IP()
+	if proxyHeader != nil && proxyHeader.SourceAddr != nil {
+		// The true L4 client, as reported by the PROXY protocol header,
+		// takes precedence over X-Forwarded-For.
+		remoteAddr = proxyHeader.SourceAddr
+	} else if tcpAddr, ok := remoteAddr.(*net.TCPAddr); ok {
+		// Only a TCP remote address can be overridden in place; a UDS
+		// listener's *net.UnixAddr has no IP to replace.
+		forwardedAddrs := http_proto.ParseXForwardedFor(request.Header)
+		if len(forwardedAddrs) > 0 && forwardedAddrs[0].Family().IsIP() {
+			tcpAddr.IP = forwardedAddrs[0].IP()
+		}
+	}
+
+	ctx := buildContext(request.Context(), proxyHeader)
+	h.ln.addConn(newConnection(ctx, conn, remoteAddr, earlyData, proxyHeader))
+}
+
+// findProxyProtoConn unwraps conn through any TLS layer to find the
+// underlying *proxyproto.Conn. When AcceptProxyProtocol is enabled, Accept()
+// returns a *proxyproto.Conn that TLS then wraps, so the PROXY protocol conn
+// is no longer the direct result of a type assertion once TLS terminates in
+// this listener.
+func findProxyProtoConn(c net.Conn) *proxyproto.Conn {
+	for {
+		switch v := c.(type) {
+		case *proxyproto.Conn:
+			return v
+		case *tls.Conn:
+			c = v.NetConn()
+		default:
+			return nil
+		}
 	}
+}
 
-	h.ln.addConn(newConnection(conn, remoteAddr))
+// extractEarlyData validates that request targets this handler's path and,
+// when early data is enabled, decodes any 0-RTT payload the client piggybacked
+// on the upgrade request. On mismatch it hands the request to handleFallback
+// and returns ok=false.
+func (h *requestHandler) extractEarlyData(writer http.ResponseWriter, request *http.Request) (io.Reader, bool) {
+	if !h.ln.config.EarlyDataEnabled {
+		if request.URL.Path != h.path {
+			h.handleFallback(writer, request)
+			return nil, false
+		}
+		return nil, true
+	}
+
+	if headerName := h.ln.config.EarlyDataHeaderName; headerName != "" {
+		if request.URL.Path != h.path {
+			h.handleFallback(writer, request)
+			return nil, false
+		}
+		b64 := request.Header.Get(headerName)
+		if b64 == "" {
+			return nil, true
+		}
+		data, err := base64.RawURLEncoding.DecodeString(b64)
+		if err != nil {
+			newError("failed to decode early data from header").Base(err).WriteToLog()
+			return nil, true
+		}
+		return bytes.NewReader(data), true
+	}
+
+	if !strings.HasPrefix(request.URL.Path, h.path) {
+		h.handleFallback(writer, request)
+		return nil, false
+	}
+	b64 := strings.TrimPrefix(request.URL.Path, h.path)
+	if b64 == "" {
+		return nil, true
+	}
+	data, err := base64.RawURLEncoding.DecodeString(b64)
+	if err != nil {
+		newError("failed to decode early data from path").Base(err).WriteToLog()
+		return nil, true
+	}
+	return bytes.NewReader(data), true
 }
 
+// serveExtendedConnect handles an RFC 8441 HTTP/2 extended CONNECT request
+// for the "websocket" protocol, wrapping the resulting bidirectional stream
+// in an http2Connection and handing it to addConn as classic upgrades do.
+// The stream's bytes are passed through as they are; WebSocket frames
+// carried inside the stream are not decoded. It reports
+// whether the request was an extended CONNECT, regardless of outcome, and
+// blocks until the tunnel is closed: once this Handler goroutine returns,
+// x/net/http2 finalizes the stream, so returning early would tear the
+// tunnel down right after the handshake.
+func (h *requestHandler) serveExtendedConnect(writer http.ResponseWriter, request *http.Request) bool {
+	if request.Method != http.MethodConnect || request.Header.Get(":protocol") != "websocket" {
+		return false
+	}
+
+	if request.URL.Path != h.path {
+		h.handleFallback(writer, request)
+		return true
+	}
+
+	flusher, ok := writer.(http.Flusher)
+	if !ok {
+		newError("http2 websocket requires a flushable ResponseWriter").WriteToLog()
+		http.Error(writer, "", http.StatusInternalServerError)
+		return true
+	}
+
+	responseHeader := writer.Header()
+	for key, value := range h.ln.config.GetResponseHeader() {
+		responseHeader[key] = value
+	}
+	writer.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	conn := newHTTP2Connection(request.Body, writer, flusher, stringAddr(request.RemoteAddr))
+	h.ln.addConn(conn)
+	conn.Wait()
+	return true
+}
+
+// stringAddr is a minimal net.Addr wrapping the address string net/http
+// already resolved for us; HTTP/2 streams have no underlying net.Conn to ask.
+type stringAddr string
+
+func (a stringAddr) Network() string { return "tcp" }
+func (a stringAddr) String() string  { return string(a) }
+
 type Listener struct {
 	sync.Mutex
 	server   http.Server
 	listener net.Listener
 	config   *Config
 	addConn  internet.ConnHandler
+	locker   *internet.FileLocker // for Unix domain socket
+}
+
+// removeStaleUnixSocket removes a Unix domain socket file left behind by an
+// unclean shutdown, so a later internet.ListenSystem on the same path doesn't
+// fail with "address already in use". A path with nothing to remove is not
+// an error.
+func removeStaleUnixSocket(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
 }
 
 func ListenWS(ctx context.Context, address net.Address, port net.Port, streamSettings *internet.MemoryStreamConfig, addConn internet.ConnHandler) (internet.Listener, error) {
-	listener, err := internet.ListenSystem(ctx, &net.TCPAddr{
-		IP:   address.IP(),
-		Port: int(port),
-	}, streamSettings.SocketSettings)
-	if err != nil {
-		return nil, newError("failed to listen TCP(for WS) on", address, ":", port).Base(err)
+	var listener net.Listener
+	var err error
+	var locker *internet.FileLocker
+	if address.Family().IsDomain() {
+		// Acquire the lock, and clean up a socket file left behind by an
+		// unclean shutdown, before binding: internet.ListenSystem would
+		// otherwise fail with "address already in use" on the stale file.
+		locker = &internet.FileLocker{
+			Path: address.Domain() + ".lock",
+		}
+		if err := locker.Acquire(); err != nil {
+			return nil, err
+		}
+		if err := removeStaleUnixSocket(address.Domain()); err != nil {
+			locker.Release()
+			return nil, newError("failed to remove stale Unix domain socket ", address).Base(err)
+		}
+
+		listener, err = internet.ListenSystem(ctx, &net.UnixAddr{
+			Name: address.Domain(),
+			Net:  "unix",
+		}, streamSettings.SocketSettings)
+		if err != nil {
+			locker.Release()
+			return nil, newError("failed to listen Unix domain socket(for WS) on ", address).Base(err)
+		}
+		newError("listening Unix domain socket(for WS) on ", address).WriteToLog(session.ExportIDToError(ctx))
+	} else {
+		listener, err = internet.ListenSystem(ctx, &net.TCPAddr{
+			IP:   address.IP(),
+			Port: int(port),
+		}, streamSettings.SocketSettings)
+		if err != nil {
+			return nil, newError("failed to listen TCP(for WS) on", address, ":", port).Base(err)
+		}
+		newError("listening TCP(for WS) on ", address, ":", port).WriteToLog(session.ExportIDToError(ctx))
 	}
-	newError("listening TCP(for WS) on ", address, ":", port).WriteToLog(session.ExportIDToError(ctx))
 
 	wsSettings := streamSettings.ProtocolSettings.(*Config)
 
@@ -128,9 +325,11 @@ func ListenWS(ctx context.Context, address net.Address, port net.Port, streamSet
 		newError("accepting PROXY protocol").AtWarning().WriteToLog(session.ExportIDToError(ctx))
 	}
 
+	var isH2 bool
 	if config := v2tls.ConfigFromStreamSettings(streamSettings); config != nil {
-		if tlsConfig := config.GetTLSConfig(); tlsConfig != nil {
+		if tlsConfig := config.GetTLSConfig(v2tls.WithNextProto("h2", "http/1.1")); tlsConfig != nil {
 			listener = tls.NewListener(listener, tlsConfig)
+			isH2 = true
 		}
 	}
 
@@ -138,17 +337,39 @@ func ListenWS(ctx context.Context, address net.Address, port net.Port, streamSet
 		config:   wsSettings,
 		addConn:  addConn,
 		listener: listener,
+		locker:   locker,
+	}
+
+	handler := &requestHandler{
+		path: wsSettings.GetNormalizedPath(),
+		ln:   l,
+	}
+
+	if wsSettings.FallbackAddr != "" {
+		target, err := url.Parse(wsSettings.FallbackAddr)
+		if err != nil {
+			return nil, newError("invalid fallback address: ", wsSettings.FallbackAddr).Base(err)
+		}
+		handler.fallback = httputil.NewSingleHostReverseProxy(target)
 	}
 
 	l.server = http.Server{
-		Handler: &requestHandler{
-			path: wsSettings.GetNormalizedPath(),
-			ln:   l,
-		},
+		Handler:           handler,
 		ReadHeaderTimeout: time.Second * 4,
 		MaxHeaderBytes:    2048,
 	}
 
+	if isH2 {
+		if err := http2.ConfigureServer(&l.server, &http2.Server{}); err != nil {
+			return nil, newError("failed to enable HTTP/2 for WebSocket listener").Base(err)
+		}
+	} else {
+		// No TLS means no ALPN negotiation, so plaintext h2c is offered
+		// directly; this lets the listener sit behind an HTTP/2-only
+		// reverse-proxy front-end without falling back to HTTP/1.1.
+		l.server.Handler = h2c.NewHandler(handler, &http2.Server{})
+	}
+
 	go func() {
 		if err := l.server.Serve(l.listener); err != nil {
 			newError("failed to serve http for WebSocket").Base(err).AtWarning().WriteToLog(session.ExportIDToError(ctx))
@@ -165,6 +386,9 @@ func (ln *Listener) Addr() net.Addr {
 
 // Close implements net.Listener.Close().
 func (ln *Listener) Close() error {
+	if ln.locker != nil {
+		ln.locker.Release()
+	}
 	return ln.listener.Close()
 }
 
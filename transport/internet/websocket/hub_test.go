@@ -0,0 +1,64 @@
+//go:build !confonly
+// +build !confonly
+
+package websocket
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestHandler(config *Config) *requestHandler {
+	return &requestHandler{
+		path: config.GetNormalizedPath(),
+		ln:   &Listener{config: config},
+	}
+}
+
+func TestServeExtendedConnect(t *testing.T) {
+	h := newTestHandler(&Config{Path: "/ws"})
+
+	t.Run("ignores non extended-CONNECT requests", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+		if h.serveExtendedConnect(httptest.NewRecorder(), req) {
+			t.Fatal("expected a plain GET to be left for the classic upgrade path")
+		}
+	})
+
+	t.Run("rejects a mismatched path instead of tunneling", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodConnect, "/other", nil)
+		req.Header.Set(":protocol", "websocket")
+
+		w := httptest.NewRecorder()
+		if !h.serveExtendedConnect(w, req) {
+			t.Fatal("expected the extended CONNECT to be handled")
+		}
+		if w.Code != http.StatusNotFound {
+			t.Fatalf("expected the fallback 404 for a mismatched path, got %d", w.Code)
+		}
+	})
+
+	t.Run("tunnels a matching path until the connection closes", func(t *testing.T) {
+		var handed net.Conn
+		h.ln.addConn = func(c net.Conn) {
+			handed = c
+			go c.Close()
+		}
+
+		req := httptest.NewRequest(http.MethodConnect, "/ws", nil)
+		req.Header.Set(":protocol", "websocket")
+
+		w := httptest.NewRecorder()
+		if !h.serveExtendedConnect(w, req) {
+			t.Fatal("expected the extended CONNECT to be handled")
+		}
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200 on a successful tunnel, got %d", w.Code)
+		}
+		if handed == nil {
+			t.Fatal("expected the tunnel to be handed to addConn")
+		}
+	})
+}
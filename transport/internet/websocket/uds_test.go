@@ -0,0 +1,35 @@
+//go:build !confonly
+// +build !confonly
+
+package websocket
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRemoveStaleUnixSocket(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Run("removes a leftover socket file", func(t *testing.T) {
+		path := filepath.Join(dir, "stale.sock")
+		if err := os.WriteFile(path, nil, 0o666); err != nil {
+			t.Fatalf("failed to create fixture: %v", err)
+		}
+
+		if err := removeStaleUnixSocket(path); err != nil {
+			t.Fatalf("got err=%v, want nil", err)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("expected socket file to be gone, stat err=%v", err)
+		}
+	})
+
+	t.Run("tolerates a path with nothing to remove", func(t *testing.T) {
+		path := filepath.Join(dir, "never-existed.sock")
+		if err := removeStaleUnixSocket(path); err != nil {
+			t.Fatalf("got err=%v, want nil", err)
+		}
+	})
+}